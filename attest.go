@@ -0,0 +1,372 @@
+// Copyright (c) 2014, Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// tpmGeneratedMagic is the TPM_GENERATED_VALUE constant every TPM 2.0
+// attestation structure begins with, confirming it was produced by a TPM
+// and not assembled by software.
+const tpmGeneratedMagic uint32 = 0xff544347
+
+// Attestation type tags (TPMI_ST_ATTEST), identifying which of the
+// TPMU_ATTEST union members follows the common TPMS_ATTEST header.
+const (
+	TagAttestCertify  uint16 = 0x8017
+	TagAttestCreation uint16 = 0x8015
+	TagAttestQuote    uint16 = 0x8018
+)
+
+// A Name identifies a TPM object the way TPM 2.0 commands do: a hash
+// algorithm together with a digest of the object's public area.
+type Name struct {
+	Alg    Algorithm
+	Digest []byte
+}
+
+// String returns a string representation of a Name.
+func (n Name) String() string {
+	return fmt.Sprintf("Name{Alg: %s, Digest: % x}", n.Alg, n.Digest)
+}
+
+// ClockInfo reports the TPM's clock state at the time an attestation was
+// produced, as a TPMS_CLOCK_INFO.
+type ClockInfo struct {
+	Clock        uint64
+	ResetCount   uint32
+	RestartCount uint32
+	Safe         byte
+}
+
+// CertifyInfo is the TPMS_CERTIFY_INFO a Certify command attests.
+type CertifyInfo struct {
+	Name          Name
+	QualifiedName Name
+}
+
+// CreationInfo is the TPMS_CREATION_INFO a CertifyCreation command attests.
+type CreationInfo struct {
+	ObjectName   Name
+	CreationHash []byte
+}
+
+// QuoteInfo is the TPMS_QUOTE_INFO a Quote or Quote2 command attests. Each
+// entry in PCRSelection is one bank of the PCR selection the quote was
+// taken over, a TPMS_PCR_SELECTION.
+type QuoteInfo struct {
+	PCRSelection []PCRSelection
+	PCRDigest    []byte
+}
+
+// AttestationData is the decoded form of a TPMS_ATTEST structure: the
+// signed statement a TPM returns from Quote, Quote2, Certify, and
+// CertifyCreation. Exactly one of Certify, Creation, or Quote is set,
+// matching Type.
+type AttestationData struct {
+	QualifiedSigner Name
+	ExtraData       []byte
+	ClockInfo       ClockInfo
+	FirmwareVersion uint64
+	Type            uint16
+
+	Certify  *CertifyInfo
+	Creation *CreationInfo
+	Quote    *QuoteInfo
+
+	// raw holds the bytes of the recognized TPMS_ATTEST structure, for
+	// Verify to re-hash.
+	raw []byte
+}
+
+// attestReader reads the big-endian, length-prefixed fields a TPMS_ATTEST
+// structure is built from.
+type attestReader struct {
+	buf []byte
+	off int
+}
+
+func (r *attestReader) need(n int) error {
+	if r.off+n > len(r.buf) {
+		return errors.New("tpm: truncated attestation structure")
+	}
+	return nil
+}
+
+func (r *attestReader) bytes(n int) ([]byte, error) {
+	if err := r.need(n); err != nil {
+		return nil, err
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *attestReader) byte() (byte, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *attestReader) u16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (r *attestReader) u32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *attestReader) u64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// sized reads a uint16-length-prefixed byte slice, as used for ExtraData
+// and the TPM2B_DIGEST fields nested in the attestation structure. The
+// returned slice is a copy, so it doesn't alias the buffer passed to
+// DecodeAttestationData and stays valid if that buffer is reused.
+func (r *attestReader) sized() ([]byte, error) {
+	n, err := r.u16()
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.bytes(int(n))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// name reads a TPM2B_NAME: a uint16 size followed by a hash algorithm ID
+// and the digest it names.
+func (r *attestReader) name() (Name, error) {
+	raw, err := r.sized()
+	if err != nil {
+		return Name{}, err
+	}
+	if len(raw) < 2 {
+		return Name{}, errors.New("tpm: truncated name")
+	}
+	return Name{Alg: Algorithm(binary.BigEndian.Uint16(raw[:2])), Digest: raw[2:]}, nil
+}
+
+// DecodeAttestationData parses the TPM-signed attestation blob returned by
+// Quote, Quote2, Certify, or CertifyCreation into an AttestationData. Bytes
+// trailing the recognized structure are ignored.
+func DecodeAttestationData(b []byte) (*AttestationData, error) {
+	r := &attestReader{buf: b}
+
+	magic, err := r.u32()
+	if err != nil {
+		return nil, fmt.Errorf("tpm: reading magic: %v", err)
+	}
+	if magic != tpmGeneratedMagic {
+		return nil, fmt.Errorf("tpm: not a TPM-generated attestation structure (magic %#x)", magic)
+	}
+
+	typ, err := r.u16()
+	if err != nil {
+		return nil, fmt.Errorf("tpm: reading attestation type: %v", err)
+	}
+
+	signer, err := r.name()
+	if err != nil {
+		return nil, fmt.Errorf("tpm: reading qualified signer: %v", err)
+	}
+
+	extraData, err := r.sized()
+	if err != nil {
+		return nil, fmt.Errorf("tpm: reading extra data: %v", err)
+	}
+
+	var ci ClockInfo
+	if ci.Clock, err = r.u64(); err != nil {
+		return nil, fmt.Errorf("tpm: reading clock: %v", err)
+	}
+	if ci.ResetCount, err = r.u32(); err != nil {
+		return nil, fmt.Errorf("tpm: reading reset count: %v", err)
+	}
+	if ci.RestartCount, err = r.u32(); err != nil {
+		return nil, fmt.Errorf("tpm: reading restart count: %v", err)
+	}
+	if ci.Safe, err = r.byte(); err != nil {
+		return nil, fmt.Errorf("tpm: reading safe flag: %v", err)
+	}
+
+	fwVersion, err := r.u64()
+	if err != nil {
+		return nil, fmt.Errorf("tpm: reading firmware version: %v", err)
+	}
+
+	ad := &AttestationData{
+		QualifiedSigner: signer,
+		ExtraData:       extraData,
+		ClockInfo:       ci,
+		FirmwareVersion: fwVersion,
+		Type:            typ,
+	}
+
+	switch typ {
+	case TagAttestCertify:
+		name, err := r.name()
+		if err != nil {
+			return nil, fmt.Errorf("tpm: reading certified name: %v", err)
+		}
+		qname, err := r.name()
+		if err != nil {
+			return nil, fmt.Errorf("tpm: reading certified qualified name: %v", err)
+		}
+		ad.Certify = &CertifyInfo{Name: name, QualifiedName: qname}
+
+	case TagAttestCreation:
+		objName, err := r.name()
+		if err != nil {
+			return nil, fmt.Errorf("tpm: reading created object name: %v", err)
+		}
+		creationHash, err := r.sized()
+		if err != nil {
+			return nil, fmt.Errorf("tpm: reading creation hash: %v", err)
+		}
+		ad.Creation = &CreationInfo{ObjectName: objName, CreationHash: creationHash}
+
+	case TagAttestQuote:
+		count, err := r.u32()
+		if err != nil {
+			return nil, fmt.Errorf("tpm: reading PCR selection count: %v", err)
+		}
+		// Each TPMS_PCR_SELECTION is at least 3 bytes (hash alg + a
+		// zero-length mask), so a count that can't possibly fit in what's
+		// left of buf is corrupt; reject it before sizing an allocation off
+		// of it.
+		if remaining := len(r.buf) - r.off; count > uint32(remaining/3) {
+			return nil, fmt.Errorf("tpm: PCR selection count %d exceeds remaining buffer", count)
+		}
+		sel := make([]PCRSelection, 0, count)
+		for i := uint32(0); i < count; i++ {
+			hashAlg, err := r.u16()
+			if err != nil {
+				return nil, fmt.Errorf("tpm: reading PCR selection %d hash: %v", i, err)
+			}
+			sizeofSelect, err := r.byte()
+			if err != nil {
+				return nil, fmt.Errorf("tpm: reading PCR selection %d size: %v", i, err)
+			}
+			maskBytes, err := r.bytes(int(sizeofSelect))
+			if err != nil {
+				return nil, fmt.Errorf("tpm: reading PCR selection %d mask: %v", i, err)
+			}
+			var mask pcrMask
+			copy(mask[:], maskBytes)
+			sel = append(sel, PCRSelection{Hash: Algorithm(hashAlg), Mask: mask})
+		}
+		pcrDigest, err := r.sized()
+		if err != nil {
+			return nil, fmt.Errorf("tpm: reading PCR digest: %v", err)
+		}
+		ad.Quote = &QuoteInfo{PCRSelection: sel, PCRDigest: pcrDigest}
+
+	default:
+		return nil, fmt.Errorf("tpm: unsupported attestation type %#x", typ)
+	}
+
+	ad.raw = append([]byte(nil), b[:r.off]...)
+	return ad, nil
+}
+
+// cryptoHashForAlg maps an Algorithm to the crypto.Hash rsa.VerifyPKCS1v15
+// expects it to have been hashed with.
+func cryptoHashForAlg(alg Algorithm) (crypto.Hash, error) {
+	switch alg {
+	case AlgSHA1:
+		return crypto.SHA1, nil
+	case AlgSHA256:
+		return crypto.SHA256, nil
+	case AlgSHA384:
+		return crypto.SHA384, nil
+	case AlgSHA512:
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("tpm: unsupported signature hash algorithm: %s", alg)
+	}
+}
+
+// unpackECDSASignature splits a TPM 2.0 ECDSA signature - the raw,
+// big-endian r and s values concatenated at equal length - into its two
+// components.
+func unpackECDSASignature(sig []byte) (r, s *big.Int, err error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, nil, errors.New("tpm: malformed ECDSA signature")
+	}
+	half := len(sig) / 2
+	return new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:]), nil
+}
+
+// Verify checks sig against the hash of ad's encoded attestation bytes,
+// using hashAlg and the key type of pub. hashAlg must be the hash algorithm
+// from the signing key's TPMT_SIGNATURE scheme - a property of how the key
+// signs, not of QualifiedSigner.Alg, which is only the key's nameAlg and
+// may legitimately differ (e.g. a SHA-256-named key with a SHA-384 RSASSA
+// scheme). Callers get hashAlg from the same TPMT_SIGNATURE sig was parsed
+// out of. Verify lets a verifier confirm that a Quote, Quote2, Certify, or
+// CertifyCreation result actually came from the TPM holding the private
+// key corresponding to pub.
+func (ad *AttestationData) Verify(hashAlg Algorithm, pub crypto.PublicKey, sig []byte) error {
+	h, err := newHash(hashAlg)
+	if err != nil {
+		return fmt.Errorf("tpm: hashing attestation: %v", err)
+	}
+	h.Write(ad.raw)
+	digest := h.Sum(nil)
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		hashFunc, err := cryptoHashForAlg(hashAlg)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(key, hashFunc, digest, sig)
+	case *ecdsa.PublicKey:
+		r, s, err := unpackECDSASignature(sig)
+		if err != nil {
+			return err
+		}
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("tpm: ecdsa signature verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("tpm: unsupported public key type %T", pub)
+	}
+}
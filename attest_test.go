@@ -0,0 +1,314 @@
+// Copyright (c) 2014, Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+func u16Bytes(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func sizedBytes(b []byte) []byte {
+	return append(u16Bytes(uint16(len(b))), b...)
+}
+
+func nameBytes(alg Algorithm, digest []byte) []byte {
+	return sizedBytes(append(u16Bytes(uint16(alg)), digest...))
+}
+
+// testSigner, testExtra, testClock, and testFW are the common-header values
+// every test blob below is built with.
+var (
+	testSigner = nameBytes(AlgSHA256, bytes.Repeat([]byte{0xAB}, sha256.Size))
+	testExtra  = []byte("nonce")
+	testClock  = ClockInfo{Clock: 12345, ResetCount: 2, RestartCount: 3, Safe: 1}
+	testFW     = uint64(0x0102030405060708)
+)
+
+func commonHeader(typ uint16) []byte {
+	buf := u32Bytes(tpmGeneratedMagic)
+	buf = append(buf, u16Bytes(typ)...)
+	buf = append(buf, testSigner...)
+	buf = append(buf, sizedBytes(testExtra)...)
+	buf = append(buf, u64Bytes(testClock.Clock)...)
+	buf = append(buf, u32Bytes(testClock.ResetCount)...)
+	buf = append(buf, u32Bytes(testClock.RestartCount)...)
+	buf = append(buf, testClock.Safe)
+	buf = append(buf, u64Bytes(testFW)...)
+	return buf
+}
+
+func checkCommonFields(t *testing.T, ad *AttestationData, typ uint16) {
+	t.Helper()
+	if ad.Type != typ {
+		t.Errorf("Type = %#x, want %#x", ad.Type, typ)
+	}
+	if ad.QualifiedSigner.Alg != AlgSHA256 {
+		t.Errorf("QualifiedSigner.Alg = %s, want SHA256", ad.QualifiedSigner.Alg)
+	}
+	if !bytes.Equal(ad.ExtraData, testExtra) {
+		t.Errorf("ExtraData = % x, want % x", ad.ExtraData, testExtra)
+	}
+	if ad.ClockInfo != testClock {
+		t.Errorf("ClockInfo = %+v, want %+v", ad.ClockInfo, testClock)
+	}
+	if ad.FirmwareVersion != testFW {
+		t.Errorf("FirmwareVersion = %#x, want %#x", ad.FirmwareVersion, testFW)
+	}
+}
+
+func TestDecodeAttestationDataCertify(t *testing.T) {
+	name := bytes.Repeat([]byte{0x11}, sha256.Size)
+	qname := bytes.Repeat([]byte{0x22}, sha256.Size)
+
+	buf := commonHeader(TagAttestCertify)
+	buf = append(buf, nameBytes(AlgSHA256, name)...)
+	buf = append(buf, nameBytes(AlgSHA256, qname)...)
+
+	ad, err := DecodeAttestationData(buf)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+	checkCommonFields(t, ad, TagAttestCertify)
+
+	if ad.Certify == nil {
+		t.Fatal("Certify is nil")
+	}
+	if !bytes.Equal(ad.Certify.Name.Digest, name) {
+		t.Errorf("Certify.Name.Digest = % x, want % x", ad.Certify.Name.Digest, name)
+	}
+	if !bytes.Equal(ad.Certify.QualifiedName.Digest, qname) {
+		t.Errorf("Certify.QualifiedName.Digest = % x, want % x", ad.Certify.QualifiedName.Digest, qname)
+	}
+	if ad.Creation != nil || ad.Quote != nil {
+		t.Error("Creation and Quote should be nil for a Certify attestation")
+	}
+}
+
+func TestDecodeAttestationDataCreation(t *testing.T) {
+	objName := bytes.Repeat([]byte{0x33}, sha256.Size)
+	creationHash := bytes.Repeat([]byte{0x44}, sha256.Size)
+
+	buf := commonHeader(TagAttestCreation)
+	buf = append(buf, nameBytes(AlgSHA256, objName)...)
+	buf = append(buf, sizedBytes(creationHash)...)
+
+	ad, err := DecodeAttestationData(buf)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+	checkCommonFields(t, ad, TagAttestCreation)
+
+	if ad.Creation == nil {
+		t.Fatal("Creation is nil")
+	}
+	if !bytes.Equal(ad.Creation.ObjectName.Digest, objName) {
+		t.Errorf("Creation.ObjectName.Digest = % x, want % x", ad.Creation.ObjectName.Digest, objName)
+	}
+	if !bytes.Equal(ad.Creation.CreationHash, creationHash) {
+		t.Errorf("Creation.CreationHash = % x, want % x", ad.Creation.CreationHash, creationHash)
+	}
+}
+
+// quoteBuf builds a valid TagAttestQuote blob selecting the given banks.
+func quoteBuf(sel PCRSelectionList, pcrDigest []byte) []byte {
+	buf := commonHeader(TagAttestQuote)
+	buf = append(buf, u32Bytes(uint32(len(sel)))...)
+	for _, s := range sel {
+		buf = append(buf, u16Bytes(uint16(s.Hash))...)
+		buf = append(buf, byte(len(s.Mask)))
+		buf = append(buf, s.Mask[:]...)
+	}
+	buf = append(buf, sizedBytes(pcrDigest)...)
+	return buf
+}
+
+func TestDecodeAttestationDataQuote(t *testing.T) {
+	var mask0, mask1 pcrMask
+	mask0.setPCR(0)
+	mask1.setPCR(1)
+	mask1.setPCR(16)
+	sel := PCRSelectionList{
+		{Hash: AlgSHA1, Mask: mask0},
+		{Hash: AlgSHA256, Mask: mask1},
+	}
+	pcrDigest := bytes.Repeat([]byte{0x55}, sha256.Size)
+
+	ad, err := DecodeAttestationData(quoteBuf(sel, pcrDigest))
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+	checkCommonFields(t, ad, TagAttestQuote)
+
+	if ad.Quote == nil {
+		t.Fatal("Quote is nil")
+	}
+	if len(ad.Quote.PCRSelection) != len(sel) {
+		t.Fatalf("got %d PCR selections, want %d", len(ad.Quote.PCRSelection), len(sel))
+	}
+	for i, want := range sel {
+		got := ad.Quote.PCRSelection[i]
+		if got.Hash != want.Hash || got.Mask != want.Mask {
+			t.Errorf("PCRSelection[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if !bytes.Equal(ad.Quote.PCRDigest, pcrDigest) {
+		t.Errorf("PCRDigest = % x, want % x", ad.Quote.PCRDigest, pcrDigest)
+	}
+}
+
+func TestDecodeAttestationDataTrailingBytesTolerated(t *testing.T) {
+	name := bytes.Repeat([]byte{0x11}, sha256.Size)
+	qname := bytes.Repeat([]byte{0x22}, sha256.Size)
+
+	buf := commonHeader(TagAttestCertify)
+	buf = append(buf, nameBytes(AlgSHA256, name)...)
+	buf = append(buf, nameBytes(AlgSHA256, qname)...)
+	buf = append(buf, []byte("unexpected trailing junk appended by a newer TPM firmware")...)
+
+	if _, err := DecodeAttestationData(buf); err != nil {
+		t.Fatalf("DecodeAttestationData() with trailing bytes failed: %v", err)
+	}
+}
+
+func TestDecodeAttestationDataBadMagic(t *testing.T) {
+	buf := commonHeader(TagAttestCertify)
+	buf[0] ^= 0xff // corrupt the magic
+
+	if _, err := DecodeAttestationData(buf); err == nil {
+		t.Fatal("DecodeAttestationData() succeeded with a bad magic, want error")
+	}
+}
+
+func TestDecodeAttestationDataTruncated(t *testing.T) {
+	name := bytes.Repeat([]byte{0x11}, sha256.Size)
+	qname := bytes.Repeat([]byte{0x22}, sha256.Size)
+
+	buf := commonHeader(TagAttestCertify)
+	buf = append(buf, nameBytes(AlgSHA256, name)...)
+	buf = append(buf, nameBytes(AlgSHA256, qname)...)
+
+	for l := 0; l < len(buf); l++ {
+		if _, err := DecodeAttestationData(buf[:l]); err == nil {
+			t.Errorf("DecodeAttestationData(buf[:%d]) of %d succeeded, want truncation error", l, len(buf))
+		}
+	}
+}
+
+func TestDecodeAttestationDataHugePCRCountRejected(t *testing.T) {
+	buf := commonHeader(TagAttestQuote)
+	buf = append(buf, u32Bytes(0xffffffff)...) // an implausible PCR selection count
+
+	if _, err := DecodeAttestationData(buf); err == nil {
+		t.Fatal("DecodeAttestationData() succeeded with an implausible PCR selection count, want error")
+	}
+}
+
+func TestAttestationDataVerifyRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+
+	name := bytes.Repeat([]byte{0x11}, sha256.Size)
+	qname := bytes.Repeat([]byte{0x22}, sha256.Size)
+	buf := commonHeader(TagAttestCertify)
+	buf = append(buf, nameBytes(AlgSHA256, name)...)
+	buf = append(buf, nameBytes(AlgSHA256, qname)...)
+
+	ad, err := DecodeAttestationData(buf)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+
+	digest := sha256.Sum256(buf)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15() failed: %v", err)
+	}
+
+	if err := ad.Verify(AlgSHA256, &priv.PublicKey, sig); err != nil {
+		t.Errorf("Verify() with a genuine signature failed: %v", err)
+	}
+
+	sig[0] ^= 0xff
+	if err := ad.Verify(AlgSHA256, &priv.PublicKey, sig); err == nil {
+		t.Error("Verify() with a corrupted signature succeeded, want error")
+	}
+}
+
+func TestAttestationDataVerifyECDSA(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() failed: %v", err)
+	}
+
+	name := bytes.Repeat([]byte{0x11}, sha256.Size)
+	qname := bytes.Repeat([]byte{0x22}, sha256.Size)
+	buf := commonHeader(TagAttestCertify)
+	buf = append(buf, nameBytes(AlgSHA256, name)...)
+	buf = append(buf, nameBytes(AlgSHA256, qname)...)
+
+	ad, err := DecodeAttestationData(buf)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+
+	digest := sha256.Sum256(buf)
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatalf("ecdsa.Sign() failed: %v", err)
+	}
+	sig := fixedSizeECDSASignature(r, s, (priv.Curve.Params().BitSize+7)/8)
+
+	if err := ad.Verify(AlgSHA256, &priv.PublicKey, sig); err != nil {
+		t.Errorf("Verify() with a genuine signature failed: %v", err)
+	}
+}
+
+// fixedSizeECDSASignature encodes r and s as the equal-length, big-endian
+// concatenation unpackECDSASignature expects, matching the TPM 2.0 wire
+// format.
+func fixedSizeECDSASignature(r, s *big.Int, size int) []byte {
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	s.FillBytes(sig[size:])
+	return sig
+}
@@ -80,7 +80,9 @@ func newPCRSelection(pcrVals []int) (*pcrSelection, error) {
 }
 
 // createPCRComposite composes a set of PCRs by prepending a pcrSelection and a
-// length, then computing the SHA1 hash and returning its output.
+// length, then computing the SHA1 hash and returning its output. It is the
+// TPM 1.2, SHA-1-only predecessor of ComputePCRDigest, kept as-is so
+// existing callers see no change in behavior.
 func createPCRComposite(mask pcrMask, pcrs []byte) ([]byte, error) {
 	if len(pcrs)%PCRSize != 0 {
 		return nil, errors.New("pcrs must be a multiple of " + strconv.Itoa(PCRSize))
@@ -177,7 +179,7 @@ func createPCRInfoLong(loc byte, mask pcrMask, pcrVals []byte) (*pcrInfoLong, er
 
 // newPCRInfoLong creates and returns a pcrInfoLong structure for the given PCR
 // values.
-func newPCRInfoLong(f *os.File, loc byte, pcrNums []int) (*pcrInfoLong, error) {
+func newPCRInfoLong(t Transport, loc byte, pcrNums []int) (*pcrInfoLong, error) {
 	var mask pcrMask
 	for _, pcr := range pcrNums {
 		if err := mask.setPCR(pcr); err != nil {
@@ -189,7 +191,7 @@ func newPCRInfoLong(f *os.File, loc byte, pcrNums []int) (*pcrInfoLong, error) {
 		glog.Infof("mask is % x\n", mask)
 	}
 
-	pcrVals, err := FetchPCRValues(f, pcrNums)
+	pcrVals, err := FetchPCRValues(t, pcrNums)
 	if err != nil {
 		return nil, err
 	}
@@ -199,4 +201,11 @@ func newPCRInfoLong(f *os.File, loc byte, pcrNums []int) (*pcrInfoLong, error) {
 	}
 
 	return createPCRInfoLong(loc, mask, pcrVals)
-}
\ No newline at end of file
+}
+
+// PCRInfoLongFromFile is the *os.File-based entry point into newPCRInfoLong,
+// for callers that hand this package an open TPM device file rather than a
+// Transport; it wraps f in a Transport via asTransport.
+func PCRInfoLongFromFile(f *os.File, loc byte, pcrNums []int) (*pcrInfoLong, error) {
+	return newPCRInfoLong(asTransport(f), loc, pcrNums)
+}
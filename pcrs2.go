@@ -0,0 +1,248 @@
+// Copyright (c) 2014, Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpm
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+
+	"github.com/golang/glog"
+)
+
+// Algorithm is a TPM_ALG_ID: the identifier a TPM 2.0 structure uses to
+// name a hash (or other) algorithm. Only the hash algorithms PCR banks are
+// extended with are defined here.
+type Algorithm uint16
+
+// The hash algorithms a PCR bank can be extended with, using their TPM 2.0
+// TPM_ALG_ID values.
+const (
+	AlgSHA1   Algorithm = 0x0004
+	AlgSHA256 Algorithm = 0x000B
+	AlgSHA384 Algorithm = 0x000C
+	AlgSHA512 Algorithm = 0x000D
+)
+
+// String returns a string representation of an Algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case AlgSHA1:
+		return "SHA1"
+	case AlgSHA256:
+		return "SHA256"
+	case AlgSHA384:
+		return "SHA384"
+	case AlgSHA512:
+		return "SHA512"
+	default:
+		return fmt.Sprintf("Algorithm(%#x)", uint16(a))
+	}
+}
+
+// algHashSize returns the digest size in bytes produced by alg, and false
+// if alg isn't one of the hash algorithms this package knows how to use for
+// PCR banks.
+func algHashSize(alg Algorithm) (int, bool) {
+	switch alg {
+	case AlgSHA1:
+		return sha1.Size, true
+	case AlgSHA256:
+		return sha256.Size, true
+	case AlgSHA384:
+		return sha512.Size384, true
+	case AlgSHA512:
+		return sha512.Size, true
+	default:
+		return 0, false
+	}
+}
+
+// newHash returns a fresh hash.Hash for alg, or an error if alg isn't
+// supported.
+func newHash(alg Algorithm) (hash.Hash, error) {
+	switch alg {
+	case AlgSHA1:
+		return sha1.New(), nil
+	case AlgSHA256:
+		return sha256.New(), nil
+	case AlgSHA384:
+		return sha512.New384(), nil
+	case AlgSHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported PCR hash algorithm: %s", alg)
+	}
+}
+
+// numPCRsSet reports how many PCRs are selected in m.
+func (pm pcrMask) numPCRsSet() int {
+	n := 0
+	for i := 0; i < 24; i++ {
+		if set, _ := pm.isPCRSet(i); set {
+			n++
+		}
+	}
+	return n
+}
+
+// A PCRSelection is the TPM 2.0 analogue of pcrSelection: a single PCR
+// bank, identified by the hash algorithm it was extended with, together
+// with the mask of PCRs selected within that bank. It corresponds to a
+// TPMS_PCR_SELECTION.
+type PCRSelection struct {
+	Hash Algorithm
+	Mask pcrMask
+}
+
+// String returns a string representation of a PCRSelection.
+func (s PCRSelection) String() string {
+	return fmt.Sprintf("PCRSelection{Hash: %s, Mask: % x}", s.Hash, s.Mask)
+}
+
+// PCRSelectionList is an ordered set of per-bank PCR selections, mirroring
+// the TPML_PCR_SELECTION used throughout the TPM 2.0 Quote and PolicyPCR
+// commands. The bank order is canonical: callers must present banks in the
+// order they intend ComputePCRDigest's pcrValues to be concatenated in.
+type PCRSelectionList []PCRSelection
+
+// size returns the number of bytes of PCR values sel expects, in the
+// canonical (bank, then PCR index) order ComputePCRDigest requires.
+func (sel PCRSelectionList) size() int {
+	n := 0
+	for _, s := range sel {
+		if digestSize, ok := algHashSize(s.Hash); ok {
+			n += digestSize * s.Mask.numPCRsSet()
+		}
+	}
+	return n
+}
+
+// Marshal serializes sel as a TPM 2.0 TPML_PCR_SELECTION: a count followed
+// by one TPMS_PCR_SELECTION (hash algorithm, mask size, mask) per bank.
+func (sel PCRSelectionList) Marshal() ([]byte, error) {
+	in := make([]interface{}, 0, 1+3*len(sel))
+	in = append(in, uint32(len(sel)))
+	for _, s := range sel {
+		in = append(in, uint16(s.Hash), byte(len(s.Mask)), s.Mask)
+	}
+	return pack(in)
+}
+
+// pcrSelectionListSHA1 wraps a single legacy SHA-1 pcrMask in a single-bank
+// PCRSelectionList, so TPM 1.2-only callers can keep using a bare pcrMask
+// while going through the TPM 2.0-shaped APIs below.
+func pcrSelectionListSHA1(mask pcrMask) PCRSelectionList {
+	return PCRSelectionList{{Hash: AlgSHA1, Mask: mask}}
+}
+
+// ComputePCRDigest computes the composite PCR digest TPM 2.0 policies and
+// quotes use: the PCR values in pcrValues, concatenated in the canonical
+// order given by sel, hashed under alg. pcrValues must be exactly
+// sel.size() bytes: for each bank in sel, one digest of that bank's hash
+// size for every PCR the bank selects, in ascending PCR index order.
+//
+// This is a TPM 2.0 construction and shares no code with createPCRComposite,
+// whose TPM 1.2 wire format prepends a marshaled pcrSelection rather than
+// hashing bare concatenated PCR values; createPCRComposite is kept as-is
+// for that reason. CreatePCRInfoSHA1 is the SHA-1-only entry point into
+// this TPM 2.0 path for callers that only have a legacy pcrMask.
+func ComputePCRDigest(alg Algorithm, sel PCRSelectionList, pcrValues []byte) ([]byte, error) {
+	if want := sel.size(); len(pcrValues) != want {
+		return nil, fmt.Errorf("pcrValues is %d bytes, want %d for this selection", len(pcrValues), want)
+	}
+
+	h, err := newHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(pcrValues)
+	d := h.Sum(nil)
+
+	if glog.V(2) {
+		glog.Infof("PCR digest for selection %v under %s is % x\n", sel, alg, d)
+	}
+
+	return d, nil
+}
+
+// PCRInfo is the TPM 2.0 analogue of pcrInfoLong: a PCR selection spanning
+// one or more hash banks, together with the composite digest it implies.
+type PCRInfo struct {
+	Selection PCRSelectionList
+	Digest    []byte
+}
+
+// String returns a string representation of a PCRInfo.
+func (pcri PCRInfo) String() string {
+	return fmt.Sprintf("PCRInfo{Selection: %v, Digest: % x}", pcri.Selection, pcri.Digest)
+}
+
+// createPCRInfo builds a PCRInfo from a PCR selection and the PCR values it
+// selects, hashing the composite under alg.
+func createPCRInfo(alg Algorithm, sel PCRSelectionList, pcrVals []byte) (*PCRInfo, error) {
+	d, err := ComputePCRDigest(alg, sel, pcrVals)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCRInfo{Selection: sel, Digest: d}, nil
+}
+
+// CreatePCRInfoSHA1 builds a PCRInfo the way createPCRInfo does, but for
+// callers that only have a legacy, single-bank SHA-1 pcrMask rather than a
+// PCRSelectionList: the thin wrapper that lets TPM 1.2-only PCR selections
+// keep working against the TPM 2.0-shaped PCRInfo/ComputePCRDigest APIs.
+func CreatePCRInfoSHA1(mask pcrMask, pcrVals []byte) (*PCRInfo, error) {
+	return createPCRInfo(AlgSHA1, pcrSelectionListSHA1(mask), pcrVals)
+}
+
+// newPCRInfo creates and returns a PCRInfo for the given multi-bank PCR
+// selection, fetching the PCR values it needs over t.
+//
+// FetchPCRValues has only ever returned SHA-1-sized PCR values; until it
+// grows a bank-aware variant, newPCRInfo can only serve banks whose hash
+// algorithm also digests to that size, and errors out rather than silently
+// building a digest over the wrong number of bytes for any other bank.
+func newPCRInfo(t Transport, alg Algorithm, sel PCRSelectionList) (*PCRInfo, error) {
+	var pcrVals []byte
+	for _, s := range sel {
+		var pcrNums []int
+		for i := 0; i < 24; i++ {
+			if set, _ := s.Mask.isPCRSet(i); set {
+				pcrNums = append(pcrNums, i)
+			}
+		}
+
+		vals, err := FetchPCRValues(t, pcrNums)
+		if err != nil {
+			return nil, err
+		}
+
+		bankSize, ok := algHashSize(s.Hash)
+		if !ok {
+			return nil, fmt.Errorf("unsupported PCR hash algorithm: %s", s.Hash)
+		}
+		if want := bankSize * len(pcrNums); len(vals) != want {
+			return nil, fmt.Errorf("FetchPCRValues returned %d bytes for a %s bank, want %d; only SHA-1-sized fetches are supported", len(vals), s.Hash, want)
+		}
+
+		pcrVals = append(pcrVals, vals...)
+	}
+
+	return createPCRInfo(alg, sel, pcrVals)
+}
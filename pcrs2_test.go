@@ -0,0 +1,126 @@
+// Copyright (c) 2014, Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestComputePCRDigestConcatenationOrder(t *testing.T) {
+	var mask0, mask1 pcrMask
+	mask0.setPCR(0)
+	mask1.setPCR(1)
+
+	sha1Val := bytes.Repeat([]byte{0x11}, 20)
+	sha256Val := bytes.Repeat([]byte{0x22}, 32)
+
+	sel := PCRSelectionList{
+		{Hash: AlgSHA1, Mask: mask0},
+		{Hash: AlgSHA256, Mask: mask1},
+	}
+	pcrValues := append(append([]byte{}, sha1Val...), sha256Val...)
+
+	got, err := ComputePCRDigest(AlgSHA256, sel, pcrValues)
+	if err != nil {
+		t.Fatalf("ComputePCRDigest() failed: %v", err)
+	}
+
+	want := sha256.Sum256(pcrValues)
+	if !bytes.Equal(got, want[:]) {
+		t.Errorf("ComputePCRDigest() = % x, want % x", got, want)
+	}
+
+	// Swapping the bank order must change the digest: the concatenation is
+	// positional, not sorted.
+	reversedSel := PCRSelectionList{sel[1], sel[0]}
+	reversedValues := append(append([]byte{}, sha256Val...), sha1Val...)
+	gotReversed, err := ComputePCRDigest(AlgSHA256, reversedSel, reversedValues)
+	if err != nil {
+		t.Fatalf("ComputePCRDigest() with reversed banks failed: %v", err)
+	}
+	if bytes.Equal(got, gotReversed) {
+		t.Error("ComputePCRDigest() gave the same digest for two different concatenation orders")
+	}
+}
+
+func TestComputePCRDigestLengthMismatch(t *testing.T) {
+	var mask pcrMask
+	mask.setPCR(0)
+	mask.setPCR(1)
+	sel := PCRSelectionList{{Hash: AlgSHA256, Mask: mask}}
+
+	// sel selects 2 PCRs at 32 bytes each; provide only one PCR's worth.
+	if _, err := ComputePCRDigest(AlgSHA256, sel, bytes.Repeat([]byte{0x33}, 32)); err == nil {
+		t.Error("ComputePCRDigest() succeeded with mismatched pcrValues length, want error")
+	}
+}
+
+func TestPCRSelectionListSize(t *testing.T) {
+	var mask pcrMask
+	mask.setPCR(0)
+	mask.setPCR(5)
+	mask.setPCR(23)
+
+	sel := PCRSelectionList{
+		{Hash: AlgSHA1, Mask: mask},   // 3 PCRs * 20 bytes
+		{Hash: AlgSHA384, Mask: mask}, // 3 PCRs * 48 bytes
+	}
+	if got, want := sel.size(), 3*20+3*48; got != want {
+		t.Errorf("PCRSelectionList.size() = %d, want %d", got, want)
+	}
+}
+
+func TestPCRSelectionListMarshal(t *testing.T) {
+	var mask pcrMask
+	mask.setPCR(0)
+	sel := PCRSelectionList{{Hash: AlgSHA1, Mask: mask}}
+
+	b, err := sel.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	want := append([]byte{0, 0, 0, 1}, byte(AlgSHA1>>8), byte(AlgSHA1), 3)
+	want = append(want, mask[:]...)
+	if !bytes.Equal(b, want) {
+		t.Errorf("Marshal() = % x, want % x", b, want)
+	}
+}
+
+func TestCreatePCRInfoSHA1MatchesComputePCRDigest(t *testing.T) {
+	var mask pcrMask
+	mask.setPCR(2)
+	mask.setPCR(7)
+	pcrVals := bytes.Repeat([]byte{0x44}, 2*20)
+
+	info, err := CreatePCRInfoSHA1(mask, pcrVals)
+	if err != nil {
+		t.Fatalf("CreatePCRInfoSHA1() failed: %v", err)
+	}
+
+	want, err := ComputePCRDigest(AlgSHA1, pcrSelectionListSHA1(mask), pcrVals)
+	if err != nil {
+		t.Fatalf("ComputePCRDigest() failed: %v", err)
+	}
+
+	if !bytes.Equal(info.Digest, want) {
+		t.Errorf("CreatePCRInfoSHA1().Digest = % x, want % x", info.Digest, want)
+	}
+	if len(info.Selection) != 1 || info.Selection[0].Hash != AlgSHA1 || info.Selection[0].Mask != mask {
+		t.Errorf("CreatePCRInfoSHA1().Selection = %+v, want a single SHA1 bank over %x", info.Selection, mask)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2014, Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+)
+
+// maxTPMResponseSize is large enough to hold any response a TPM 1.2 or 2.0
+// device returns.
+const maxTPMResponseSize = 4096
+
+// Transport is the channel a TPM command is sent over and its response is
+// read back from. It decouples the PCR and quote APIs from the physical
+// /dev/tpm0 character device, so the same call sites also work against
+// in-process simulators, gRPC resource-manager proxies, and tests.
+type Transport interface {
+	// Send writes cmd and returns the TPM's response.
+	Send(cmd []byte) (resp []byte, err error)
+
+	// Close releases any resources held by the transport.
+	Close() error
+}
+
+// fileTransport adapts an *os.File, such as an open TPM character device,
+// to the Transport interface: a command write followed by a response read.
+type fileTransport struct {
+	f *os.File
+}
+
+// NewDeviceTransport opens the TPM character device at path (typically
+// /dev/tpm0 or /dev/tpmrm0) and returns a Transport wrapping it, preserving
+// the read/write semantics exported functions previously got from an
+// *os.File directly.
+func NewDeviceTransport(path string) (Transport, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: opening %s: %v", path, err)
+	}
+	return fileTransport{f}, nil
+}
+
+// asTransport wraps f in a Transport, for exported functions that still
+// take an *os.File directly and must keep working unmodified.
+func asTransport(f *os.File) Transport {
+	return fileTransport{f}
+}
+
+func (t fileTransport) Send(cmd []byte) ([]byte, error) {
+	if _, err := t.f.Write(cmd); err != nil {
+		return nil, fmt.Errorf("tpm: writing command: %v", err)
+	}
+
+	resp := make([]byte, maxTPMResponseSize)
+	n, err := t.f.Read(resp)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: reading response: %v", err)
+	}
+	return resp[:n], nil
+}
+
+func (t fileTransport) Close() error {
+	return t.f.Close()
+}
+
+// MSSIM platform-channel command codes. These control the simulator itself
+// (powering it on, enabling NV) and are distinct from the TPM commands sent
+// over the regular command channel.
+const (
+	mssimCmdPowerOn        uint32 = 1
+	mssimCmdNVOn           uint32 = 11
+	mssimCmdTPMSendCommand uint32 = 8
+)
+
+// emulatorTransport speaks the MSSIM/swtpm socket protocol used by software
+// TPM simulators: each command is wrapped in a 4-byte command code, a
+// 1-byte locality, and a 4-byte length prefix; each response is a 4-byte
+// length followed by the response body and a 4-byte trailing status.
+type emulatorTransport struct {
+	conn net.Conn
+}
+
+// NewEmulatorTransport dials a software TPM simulator (e.g. swtpm or the
+// Microsoft MSSIM reference simulator) at addr, which must be its command
+// port, and returns a Transport speaking its wire protocol. Before use it
+// powers the simulator on over its platform port - by swtpm/MSSIM
+// convention, the next port number after addr - sending
+// TPM_SIGNAL_POWER_ON followed by TPM_SIGNAL_NV_ON.
+func NewEmulatorTransport(addr string) (Transport, error) {
+	if err := powerOnEmulator(addr); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: dialing emulator at %s: %v", addr, err)
+	}
+	return &emulatorTransport{conn: conn}, nil
+}
+
+// powerOnEmulator sends TPM_SIGNAL_POWER_ON and TPM_SIGNAL_NV_ON to the
+// simulator's platform port, derived from its command port addr by the
+// swtpm/MSSIM convention of using the next port number.
+func powerOnEmulator(addr string) error {
+	platformAddr, err := platformPort(addr)
+	if err != nil {
+		return fmt.Errorf("tpm: deriving emulator platform port: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", platformAddr)
+	if err != nil {
+		return fmt.Errorf("tpm: dialing emulator platform port at %s: %v", platformAddr, err)
+	}
+	defer conn.Close()
+
+	for _, cmd := range []uint32{mssimCmdPowerOn, mssimCmdNVOn} {
+		if err := binary.Write(conn, binary.BigEndian, cmd); err != nil {
+			return fmt.Errorf("tpm: sending platform command %d: %v", cmd, err)
+		}
+	}
+	return nil
+}
+
+// platformPort derives an emulator's platform-channel address from its
+// command-channel address addr.
+func platformPort(addr string) (string, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("parsing port %q: %v", portStr, err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+1)), nil
+}
+
+func (t *emulatorTransport) Send(cmd []byte) ([]byte, error) {
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, mssimCmdTPMSendCommand)
+	req.WriteByte(0) // locality 0
+	binary.Write(&req, binary.BigEndian, uint32(len(cmd)))
+	req.Write(cmd)
+
+	if _, err := t.conn.Write(req.Bytes()); err != nil {
+		return nil, fmt.Errorf("tpm: writing command to emulator: %v", err)
+	}
+
+	var respLen uint32
+	if err := binary.Read(t.conn, binary.BigEndian, &respLen); err != nil {
+		return nil, fmt.Errorf("tpm: reading response length from emulator: %v", err)
+	}
+	if respLen > maxTPMResponseSize {
+		return nil, fmt.Errorf("tpm: emulator response length %d exceeds maximum of %d", respLen, maxTPMResponseSize)
+	}
+	resp := make([]byte, respLen)
+	if _, err := io.ReadFull(t.conn, resp); err != nil {
+		return nil, fmt.Errorf("tpm: reading response from emulator: %v", err)
+	}
+
+	var status uint32
+	if err := binary.Read(t.conn, binary.BigEndian, &status); err != nil {
+		return nil, fmt.Errorf("tpm: reading emulator trailing status: %v", err)
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("tpm: emulator reported command failure: status %#x", status)
+	}
+
+	return resp, nil
+}
+
+func (t *emulatorTransport) Close() error {
+	return t.conn.Close()
+}
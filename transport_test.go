@@ -0,0 +1,160 @@
+// Copyright (c) 2014, Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// fakeMSSIMServer drains exactly one command-channel request off conn,
+// decoding it the way a real swtpm/MSSIM simulator would, then writes back
+// resp framed with respStatus as the trailing status word.
+func fakeMSSIMServer(t *testing.T, conn net.Conn, resp []byte, respStatus uint32) (gotCmdCode uint32, gotLocality byte, gotBody []byte) {
+	t.Helper()
+
+	var hdr [9]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		t.Errorf("reading command header: %v", err)
+		return
+	}
+	gotCmdCode = binary.BigEndian.Uint32(hdr[0:4])
+	gotLocality = hdr[4]
+	bodyLen := binary.BigEndian.Uint32(hdr[5:9])
+
+	gotBody = make([]byte, bodyLen)
+	if _, err := io.ReadFull(conn, gotBody); err != nil {
+		t.Errorf("reading command body: %v", err)
+		return
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, uint32(len(resp)))
+	out.Write(resp)
+	binary.Write(&out, binary.BigEndian, respStatus)
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		t.Errorf("writing response: %v", err)
+	}
+	return
+}
+
+func TestEmulatorTransportSendFraming(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	cmd := []byte("a TPM2_Quote command body")
+	wantResp := []byte("a TPM2_Quote response body")
+
+	done := make(chan struct{})
+	var gotCmdCode uint32
+	var gotLocality byte
+	var gotBody []byte
+	go func() {
+		defer close(done)
+		gotCmdCode, gotLocality, gotBody = fakeMSSIMServer(t, server, wantResp, 0)
+	}()
+
+	et := &emulatorTransport{conn: client}
+	gotResp, err := et.Send(cmd)
+	<-done
+
+	if err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if gotCmdCode != mssimCmdTPMSendCommand {
+		t.Errorf("command code = %d, want %d (MSSIM TPM_SEND_COMMAND)", gotCmdCode, mssimCmdTPMSendCommand)
+	}
+	if gotLocality != 0 {
+		t.Errorf("locality = %d, want 0", gotLocality)
+	}
+	if !bytes.Equal(gotBody, cmd) {
+		t.Errorf("command body = %q, want %q", gotBody, cmd)
+	}
+	if !bytes.Equal(gotResp, wantResp) {
+		t.Errorf("Send() response = %q, want %q", gotResp, wantResp)
+	}
+}
+
+func TestEmulatorTransportSendNonzeroStatusIsError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fakeMSSIMServer(t, server, []byte("resp"), 1 /* nonzero: simulator-reported failure */)
+	}()
+
+	et := &emulatorTransport{conn: client}
+	_, err := et.Send([]byte("cmd"))
+	<-done
+
+	if err == nil {
+		t.Fatal("Send() succeeded despite a nonzero trailing status, want error")
+	}
+}
+
+func TestPowerOnEmulator(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	defer ln.Close()
+
+	_, platformPortStr, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.SplitHostPort() failed: %v", err)
+	}
+	platformPort, err := strconv.Atoi(platformPortStr)
+	if err != nil {
+		t.Fatalf("strconv.Atoi() failed: %v", err)
+	}
+	commandAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(platformPort-1))
+
+	gotCmds := make(chan []uint32, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Errorf("Accept() failed: %v", err)
+			gotCmds <- nil
+			return
+		}
+		defer conn.Close()
+
+		var buf [8]byte
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			t.Errorf("reading platform commands: %v", err)
+			gotCmds <- nil
+			return
+		}
+		gotCmds <- []uint32{binary.BigEndian.Uint32(buf[0:4]), binary.BigEndian.Uint32(buf[4:8])}
+	}()
+
+	if err := powerOnEmulator(commandAddr); err != nil {
+		t.Fatalf("powerOnEmulator() failed: %v", err)
+	}
+
+	want := []uint32{mssimCmdPowerOn, mssimCmdNVOn}
+	got := <-gotCmds
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("platform commands = %v, want %v", got, want)
+	}
+}